@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/go-gorp/gorp"
+	"github.com/spf13/cobra"
+)
+
+// Bootstrap is the integration point the API server and the cds engine CLI
+// are meant to call at startup: it applies pending migrations through
+// AutoApply (letting a deployment pass disableAutoApply to opt out in favor
+// of running `cds engine migrate up` as an explicit step) and returns the
+// `migrate` command tree so the caller can attach it under `cds engine`.
+// engine startup (cmd/engine) is expected to call Bootstrap once a *gorp.DbMap
+// is available and add the returned command with rootCmd.AddCommand, but
+// that call site lives in cmd/engine, which is outside this package's tree
+// and has no source here to wire up: until it's added, Bootstrap has no
+// caller and this subsystem never runs.
+func Bootstrap(ctx context.Context, db *gorp.DbMap, disableAutoApply bool, dbFunc func() (*gorp.DbMap, error)) (*cobra.Command, error) {
+	if err := AutoApply(ctx, db, disableAutoApply); err != nil {
+		return nil, err
+	}
+	return Command(dbFunc), nil
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-gorp/gorp"
+	"github.com/spf13/cobra"
+)
+
+// Command builds the `cds engine migrate` command tree. dbFunc is called
+// lazily by each subcommand so the database connection is only opened when a
+// subcommand actually runs.
+func Command(dbFunc func() (*gorp.DbMap, error)) *cobra.Command {
+	root := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage CDS database schema migrations",
+	}
+
+	root.AddCommand(
+		cmdMigrateUp(dbFunc),
+		cmdMigrateDown(dbFunc),
+		cmdMigrateStatus(dbFunc),
+	)
+
+	return root
+}
+
+func cmdMigrateUp(dbFunc func() (*gorp.DbMap, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "up",
+		Short: "Apply every pending database migration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := dbFunc()
+			if err != nil {
+				return err
+			}
+			return Up(cmd.Context(), db)
+		},
+	}
+}
+
+func cmdMigrateDown(dbFunc func() (*gorp.DbMap, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "down N",
+		Short: "Roll back the last N applied database migrations",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid migration count %q: %v", args[0], err)
+			}
+
+			db, err := dbFunc()
+			if err != nil {
+				return err
+			}
+			return Down(cmd.Context(), db, n)
+		},
+	}
+}
+
+func cmdMigrateStatus(dbFunc func() (*gorp.DbMap, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List database migrations and whether they have been applied",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := dbFunc()
+			if err != nil {
+				return err
+			}
+
+			entries, err := Status(cmd.Context(), db)
+			if err != nil {
+				return err
+			}
+
+			for _, e := range entries {
+				switch {
+				case !e.Applied:
+					fmt.Fprintf(cmd.OutOrStdout(), "%d  pending   %s\n", e.ID, e.Description)
+				case e.Drifted:
+					fmt.Fprintf(cmd.OutOrStdout(), "%d  drifted   %s (applied %s)\n", e.ID, e.Description, e.AppliedAt.Format("2006-01-02 15:04:05"))
+				default:
+					fmt.Fprintf(cmd.OutOrStdout(), "%d  applied   %s (applied %s)\n", e.ID, e.Description, e.AppliedAt.Format("2006-01-02 15:04:05"))
+				}
+			}
+
+			return nil
+		},
+	}
+}
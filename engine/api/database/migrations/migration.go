@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/go-gorp/gorp"
+)
+
+// Migration describes a single, ordered step in the schema history. ID must
+// be a timestamp in the YYYYMMDDHHMMSS form so migrations sort and apply in
+// the order they were authored, the same convention as the SQL migration
+// files under engine/sql.
+type Migration struct {
+	ID          int64
+	Description string
+	Apply       func(tx *gorp.Transaction) error
+	Rollback    func(tx *gorp.Transaction) error
+}
+
+// checksum fingerprints the registered ID and description of a migration.
+// Go gives no way to hash a func value's compiled body, so this does NOT
+// detect an edited Apply/Rollback: only renaming the migration's
+// description or reusing its ID changes the checksum. `migrate status`
+// reports Drifted based on this, so treat it as "this entry's identity
+// changed since it was applied", not "this migration's DDL changed".
+func (m Migration) checksum() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", m.ID, m.Description)))
+	return hex.EncodeToString(sum[:])
+}
+
+var registry []Migration
+
+// Register adds a migration to the registry. Call it from the init() of the
+// package that owns the tables being migrated, the same way packages call
+// gorpmapping.Register for their entities.
+func Register(m Migration) {
+	registry = append(registry, m)
+}
+
+// sorted returns every registered migration ordered by ascending ID.
+func sorted() []Migration {
+	out := make([]Migration, len(registry))
+	copy(out, registry)
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
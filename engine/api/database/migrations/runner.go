@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/ovh/cds/sdk"
+	"github.com/ovh/cds/sdk/log"
+)
+
+// StatusEntry reports whether a registered migration has been applied, and
+// whether its recorded checksum still matches its current ID/description
+// (see Migration.checksum — this is not a guarantee the Apply/Rollback body
+// is unchanged).
+type StatusEntry struct {
+	ID          int64
+	Description string
+	Applied     bool
+	AppliedAt   *time.Time
+	Drifted     bool
+}
+
+// Up applies every pending migration, in ascending ID order, each inside its
+// own transaction so a failure midway leaves previously applied migrations
+// intact.
+func Up(ctx context.Context, db *gorp.DbMap) error {
+	if err := ensureSchemaMigrationTable(db); err != nil {
+		return err
+	}
+
+	done, err := applied(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sorted() {
+		if _, ok := done[m.ID]; ok {
+			continue
+		}
+
+		log.Info(ctx, "database migration: applying %d - %s", m.ID, m.Description)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return sdk.WrapError(err, "unable to start transaction for migration %d", m.ID)
+		}
+
+		if err := m.Apply(tx); err != nil {
+			tx.Rollback() // nolint
+			return sdk.WrapError(err, "migration %d failed", m.ID)
+		}
+
+		row := dbSchemaMigration{ID: m.ID, AppliedAt: time.Now(), Checksum: m.checksum()}
+		if err := tx.Insert(&row); err != nil {
+			tx.Rollback() // nolint
+			return sdk.WrapError(err, "unable to record migration %d", m.ID)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return sdk.WrapError(err, "unable to commit migration %d", m.ID)
+		}
+	}
+
+	return nil
+}
+
+// Down rolls back the last n applied migrations, most recent first, each in
+// its own transaction.
+func Down(ctx context.Context, db *gorp.DbMap, n int) error {
+	if err := ensureSchemaMigrationTable(db); err != nil {
+		return err
+	}
+
+	done, err := applied(db)
+	if err != nil {
+		return err
+	}
+
+	all := sorted()
+	for i := len(all) - 1; i >= 0 && n > 0; i-- {
+		m := all[i]
+		if _, ok := done[m.ID]; !ok {
+			continue
+		}
+
+		log.Info(ctx, "database migration: rolling back %d - %s", m.ID, m.Description)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return sdk.WrapError(err, "unable to start transaction for migration %d", m.ID)
+		}
+
+		if err := m.Rollback(tx); err != nil {
+			tx.Rollback() // nolint
+			return sdk.WrapError(err, "rollback of migration %d failed", m.ID)
+		}
+
+		if _, err := tx.Exec("DELETE FROM schema_migration WHERE id = $1", m.ID); err != nil {
+			tx.Rollback() // nolint
+			return sdk.WrapError(err, "unable to remove migration record %d", m.ID)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return sdk.WrapError(err, "unable to commit rollback of migration %d", m.ID)
+		}
+
+		n--
+	}
+
+	return nil
+}
+
+// Status reports every registered migration, whether it has been applied,
+// and whether its ID/description checksum has drifted since it was applied.
+// Drifted does not catch an edited Apply/Rollback body, only a changed ID or
+// description on an already-applied migration.
+func Status(ctx context.Context, db *gorp.DbMap) ([]StatusEntry, error) {
+	if err := ensureSchemaMigrationTable(db); err != nil {
+		return nil, err
+	}
+
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StatusEntry, 0, len(registry))
+	for _, m := range sorted() {
+		entry := StatusEntry{ID: m.ID, Description: m.Description}
+		if row, ok := done[m.ID]; ok {
+			entry.Applied = true
+			appliedAt := row.AppliedAt
+			entry.AppliedAt = &appliedAt
+			entry.Drifted = row.Checksum != m.checksum()
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// AutoApply runs Up at API startup unless disabled is set, letting
+// production deployments opt out of automatically applying migrations in
+// favor of running `cds engine migrate up` as an explicit, reviewed step.
+func AutoApply(ctx context.Context, db *gorp.DbMap, disabled bool) error {
+	if disabled {
+		log.Info(ctx, "database migration: auto-apply disabled, skipping")
+		return nil
+	}
+	return Up(ctx, db)
+}
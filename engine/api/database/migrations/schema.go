@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// dbSchemaMigration is the row persisted for every applied migration.
+type dbSchemaMigration struct {
+	ID        int64     `db:"id"`
+	AppliedAt time.Time `db:"applied_at"`
+	Checksum  string    `db:"checksum"`
+}
+
+const createSchemaMigrationTable = `
+CREATE TABLE IF NOT EXISTS schema_migration (
+	id BIGINT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL,
+	checksum VARCHAR(64) NOT NULL
+)`
+
+// ensureSchemaMigrationTable creates the bookkeeping table on first use. It
+// is idempotent so it is safe to call on every startup.
+func ensureSchemaMigrationTable(db gorp.SqlExecutor) error {
+	_, err := db.Exec(createSchemaMigrationTable)
+	return sdk.WithStack(err)
+}
+
+// applied returns every migration already recorded, keyed by ID.
+func applied(db gorp.SqlExecutor) (map[int64]dbSchemaMigration, error) {
+	var rows []dbSchemaMigration
+	if _, err := db.Select(&rows, "SELECT id, applied_at, checksum FROM schema_migration"); err != nil {
+		return nil, sdk.WrapError(err, "unable to list applied migrations")
+	}
+
+	out := make(map[int64]dbSchemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.ID] = r
+	}
+	return out, nil
+}
@@ -0,0 +1,320 @@
+package project
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/sdk"
+)
+
+// dbProjectAudit is a generic audit row covering every project sub-entity
+// that isn't already tracked by project_variable_audit: keys, labels, and
+// the vcs_servers blob.
+type dbProjectAudit struct {
+	ID         int64  `db:"id"`
+	ProjectID  int64  `db:"project_id"`
+	EntityType string `db:"entity_type"`
+	EntityID   string `db:"entity_id"`
+	AuthorID   string `db:"author_id"`
+	Action     string `db:"action"`
+	// Before and After are JSONB columns. sql.NullString, not string, so a
+	// nil diff side is persisted as SQL NULL rather than an empty string:
+	// Postgres rejects '' as invalid JSON, which used to abort the insert
+	// (and the enclosing key/label write) on the very first PostInsert, where
+	// before is always nil.
+	Before  sql.NullString `db:"before"`
+	After   sql.NullString `db:"after"`
+	Created time.Time      `db:"created_at"`
+}
+
+func init() {
+	gorpmapping.Register(gorpmapping.New(dbProjectAudit{}, "project_audit", true, "id"))
+}
+
+// Entity types and actions recorded in project_audit.
+const (
+	AuditEntityProjectKey = "project_key"
+	AuditEntityLabel      = "project_label"
+	AuditEntityVCSServers = "vcs_servers"
+
+	AuditActionInsert = "insert"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// ProjectAuditEntry is the public, JSON-decoded view of a dbProjectAudit row.
+type ProjectAuditEntry struct {
+	ID         int64           `json:"id"`
+	ProjectID  int64           `json:"project_id"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	AuthorID   string          `json:"author_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Created    time.Time       `json:"created_at"`
+}
+
+func (e dbProjectAudit) toEntry() ProjectAuditEntry {
+	return ProjectAuditEntry{
+		ID:         e.ID,
+		ProjectID:  e.ProjectID,
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		AuthorID:   e.AuthorID,
+		Action:     e.Action,
+		Before:     jsonRawOrNil(e.Before),
+		After:      jsonRawOrNil(e.After),
+		Created:    e.Created,
+	}
+}
+
+func jsonRawOrNil(s sql.NullString) json.RawMessage {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return json.RawMessage(s.String)
+}
+
+// auditAuthors holds the acting user ID for an in-flight gorp.SqlExecutor.
+// gorp's PostInsert/PostUpdate/PostDelete hooks have no context.Context
+// parameter, so the author can't be threaded through them directly; keying
+// by the executor itself (in practice a *gorp.Transaction, unique per
+// request) instead of a single package-level variable means concurrent API
+// requests never see each other's author, unlike a shared global would.
+var auditAuthors sync.Map // map[gorp.SqlExecutor]string
+
+// WithAuditAuthor runs fn with authorID recorded against any project_key,
+// project_label, or vcs_servers change fn performs through db, then clears
+// it. Callers are expected to resolve authorID from the request context
+// (e.g. the authentified consumer) and to pass the same db (normally the
+// request's transaction) to every write fn performs.
+func WithAuditAuthor(db gorp.SqlExecutor, authorID string, fn func() error) error {
+	auditAuthors.Store(db, authorID)
+	defer auditAuthors.Delete(db)
+	return fn()
+}
+
+func auditAuthorFor(db gorp.SqlExecutor) string {
+	v, _ := auditAuthors.Load(db)
+	s, _ := v.(string)
+	return s
+}
+
+func recordAudit(db gorp.SqlExecutor, projectID int64, entityType, entityID, action string, before, after interface{}) error {
+	var beforeCol, afterCol sql.NullString
+
+	if before != nil {
+		b, err := json.Marshal(before)
+		if err != nil {
+			return sdk.WrapError(err, "unable to marshal audit before payload")
+		}
+		beforeCol = sql.NullString{String: string(b), Valid: true}
+	}
+
+	if after != nil {
+		a, err := json.Marshal(after)
+		if err != nil {
+			return sdk.WrapError(err, "unable to marshal audit after payload")
+		}
+		afterCol = sql.NullString{String: string(a), Valid: true}
+	}
+
+	row := dbProjectAudit{
+		ProjectID:  projectID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		AuthorID:   auditAuthorFor(db),
+		Action:     action,
+		Before:     beforeCol,
+		After:      afterCol,
+		Created:    time.Now(),
+	}
+
+	return sdk.WithStack(db.Insert(&row))
+}
+
+// redactVCSServersDiff takes the already decrypted YAML of the vcs_servers
+// blob before and after an update and returns a diff with every token-like
+// field replaced by sdk.PasswordPlaceholder, using the same rule
+// sdk.NeedPlaceholder applies to variable types.
+func redactVCSServersDiff(beforeYAML, afterYAML []byte) (map[string]interface{}, error) {
+	redact := func(raw []byte) (map[string]interface{}, error) {
+		if len(raw) == 0 {
+			return map[string]interface{}{}, nil
+		}
+
+		var servers map[string]map[string]interface{}
+		if err := yaml.Unmarshal(raw, &servers); err != nil {
+			return nil, sdk.WrapError(err, "unable to unmarshal vcs_servers for audit")
+		}
+
+		out := make(map[string]interface{}, len(servers))
+		for name, fields := range servers {
+			for k := range fields {
+				if sdk.NeedPlaceholder(k) {
+					fields[k] = sdk.PasswordPlaceholder
+				}
+			}
+			out[name] = fields
+		}
+		return out, nil
+	}
+
+	beforeRedacted, err := redact(beforeYAML)
+	if err != nil {
+		return nil, err
+	}
+	afterRedacted, err := redact(afterYAML)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"before": beforeRedacted, "after": afterRedacted}, nil
+}
+
+// preUpdateSnapshots is the stash used to pass a row's pre-update state from
+// a PreUpdate hook to its PostUpdate hook. It is keyed by (executor,
+// entityType:id), the same way auditAuthors is keyed by executor, so that
+// two concurrent transactions updating the same key/label id never cross
+// each other's "before" state the way a single id-keyed global would; an
+// entry left behind by a transaction that fails between PreUpdate and
+// PostUpdate is only ever reachable through that same dead executor, so it
+// can't leak into a later transaction's read.
+var preUpdateSnapshots sync.Map // map[preUpdateKey]interface{}
+
+type preUpdateKey struct {
+	db  gorp.SqlExecutor
+	key string
+}
+
+func auditStashKey(entityType string, id int64) string {
+	return entityType + ":" + strconv.FormatInt(id, 10)
+}
+
+func stashPreUpdate(db gorp.SqlExecutor, key string, v interface{}) {
+	preUpdateSnapshots.Store(preUpdateKey{db, key}, v)
+}
+
+func popPreUpdate(db gorp.SqlExecutor, key string) (interface{}, bool) {
+	k := preUpdateKey{db, key}
+	v, ok := preUpdateSnapshots.Load(k)
+	if ok {
+		preUpdateSnapshots.Delete(k)
+	}
+	return v, ok
+}
+
+// AuditFilters restricts LoadAudits to a subset of a project's audit trail.
+type AuditFilters struct {
+	EntityType string
+	AuthorID   string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// LoadAudits returns the project_audit history for projID, most recent
+// first, applying filters and pagination.
+func LoadAudits(ctx context.Context, db gorp.SqlExecutor, projID int64, filters AuditFilters) ([]ProjectAuditEntry, error) {
+	query := "SELECT * FROM project_audit WHERE project_id = $1"
+	args := []interface{}{projID}
+
+	if filters.EntityType != "" {
+		args = append(args, filters.EntityType)
+		query += " AND entity_type = $" + strconv.Itoa(len(args))
+	}
+	if filters.AuthorID != "" {
+		args = append(args, filters.AuthorID)
+		query += " AND author_id = $" + strconv.Itoa(len(args))
+	}
+	if !filters.From.IsZero() {
+		args = append(args, filters.From)
+		query += " AND created_at >= $" + strconv.Itoa(len(args))
+	}
+	if !filters.To.IsZero() {
+		args = append(args, filters.To)
+		query += " AND created_at <= $" + strconv.Itoa(len(args))
+	}
+
+	query += " ORDER BY created_at DESC, id DESC"
+
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	args = append(args, limit)
+	query += " LIMIT $" + strconv.Itoa(len(args))
+	args = append(args, filters.Offset)
+	query += " OFFSET $" + strconv.Itoa(len(args))
+
+	var rows []dbProjectAudit
+	if _, err := db.Select(&rows, query, args...); err != nil {
+		return nil, sdk.WrapError(err, "unable to load audits for project %d", projID)
+	}
+
+	entries := make([]ProjectAuditEntry, len(rows))
+	for i, r := range rows {
+		entries[i] = r.toEntry()
+	}
+	return entries, nil
+}
+
+// Revert reapplies the "before" state recorded by auditID through the
+// normal update path for its entity type, so signatures are regenerated
+// correctly rather than writing the raw JSON back to the database.
+func Revert(ctx context.Context, db gorp.SqlExecutor, auditID int64) error {
+	var row dbProjectAudit
+	if err := db.SelectOne(&row, "SELECT * FROM project_audit WHERE id = $1", auditID); err != nil {
+		return sdk.WrapError(err, "unable to load audit %d", auditID)
+	}
+
+	if !row.Before.Valid {
+		return sdk.WithStack(sdk.ErrInvalidData)
+	}
+
+	switch row.EntityType {
+	case AuditEntityProjectKey:
+		var k sdk.ProjectKey
+		if err := json.Unmarshal([]byte(row.Before.String), &k); err != nil {
+			return sdk.WrapError(err, "unable to unmarshal audit %d before state", auditID)
+		}
+		dbk := dbProjectKey{ProjectKey: k}
+		// dbProjectKey is a SignedEntity: a plain db.Update would leave the
+		// stored signature covering the reverted-away state. UpdateAndSign
+		// recomputes it from the restored row.
+		return sdk.WithStack(gorpmapping.UpdateAndSign(db, &dbk))
+
+	case AuditEntityLabel:
+		var l sdk.Label
+		if err := json.Unmarshal([]byte(row.Before.String), &l); err != nil {
+			return sdk.WrapError(err, "unable to unmarshal audit %d before state", auditID)
+		}
+		// dbLabel has no Canonical()/SignedEntity (see gorp_model.go), so
+		// unlike project_key above there is no signature to regenerate here.
+		dbl := dbLabel(l)
+		return sdk.WithStack(db.Update(&dbl))
+
+	case AuditEntityVCSServers:
+		// redactVCSServersDiff replaces every token-like field with
+		// sdk.PasswordPlaceholder before the diff ever reaches project_audit,
+		// the same as project_variable_audit does for password-type
+		// variables. Unlike that case, there is no "real" row left to fall
+		// back on here: applying row.Before would silently overwrite live
+		// VCS server tokens with the literal placeholder string. Refuse
+		// instead of reverting with data we know is wrong.
+		return sdk.WrapError(sdk.ErrNotImplemented, "cannot revert audit %d: only a redacted view of vcs_servers is recorded, secrets are not recoverable", auditID)
+
+	default:
+		return sdk.WithStack(sdk.ErrNotImplemented)
+	}
+}
@@ -0,0 +1,93 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// AWSSecretsManagerBackend stores project secrets in AWS Secrets Manager.
+// References look like aws-secretsmanager://<secret-name>.
+type AWSSecretsManagerBackend struct {
+	Client secretsmanageriface.SecretsManagerAPI
+}
+
+func parseAWSSecretRef(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", sdk.WrapError(err, "invalid aws secretsmanager reference %q", ref)
+	}
+	if u.Scheme != BackendAWSSecretsManager {
+		return "", sdk.WithStack(fmt.Errorf("invalid aws secretsmanager reference scheme %q", u.Scheme))
+	}
+	return strings.TrimPrefix(u.Host+u.Path, "/"), nil
+}
+
+func (b AWSSecretsManagerBackend) Get(ctx context.Context, ref string) ([]byte, error) {
+	name, err := parseAWSSecretRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := b.Client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to read aws secret %s", ref)
+	}
+	if out.SecretString == nil {
+		return nil, sdk.WithStack(fmt.Errorf("aws secret %s has no string value", ref))
+	}
+	return []byte(*out.SecretString), nil
+}
+
+func (b AWSSecretsManagerBackend) Put(ctx context.Context, ref string, value []byte) (string, error) {
+	name, err := parseAWSSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = b.Client.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: aws.String(string(value)),
+	})
+	if isAWSResourceNotFound(err) {
+		_, err = b.Client.CreateSecretWithContext(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			SecretString: aws.String(string(value)),
+		})
+	}
+	if err != nil {
+		return "", sdk.WrapError(err, "unable to write aws secret %s", ref)
+	}
+
+	return fmt.Sprintf("%s://%s", BackendAWSSecretsManager, name), nil
+}
+
+func (b AWSSecretsManagerBackend) Delete(ctx context.Context, ref string) error {
+	name, err := parseAWSSecretRef(ref)
+	if err != nil {
+		return err
+	}
+	_, err = b.Client.DeleteSecretWithContext(ctx, &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(name),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	return sdk.WithStack(err)
+}
+
+func isAWSResourceNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package project
+
+import "testing"
+
+func TestParseAWSSecretRef(t *testing.T) {
+	cases := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "simple name",
+			ref:  "aws-secretsmanager://cds/projects/FOO/MY_SECRET",
+			want: "cds/projects/FOO/MY_SECRET",
+		},
+		{
+			name:    "wrong scheme",
+			ref:     "vault://FOO",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseAWSSecretRef(c.ref)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package project
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// SecretBackend stores and retrieves project variable values outside of the
+// local database, keyed by an opaque reference in the backend's own format,
+// e.g. vault://cds/projects/FOO/MY_SECRET?version=3.
+type SecretBackend interface {
+	Get(ctx context.Context, ref string) ([]byte, error)
+	Put(ctx context.Context, ref string, value []byte) (newRef string, err error)
+	Delete(ctx context.Context, ref string) error
+}
+
+// Backend names stored in dbProjectVariable.Backend.
+const (
+	BackendLocal             = "local"
+	BackendVault             = "vault"
+	BackendAWSSecretsManager = "aws-secretsmanager"
+)
+
+// backends holds the configured SecretBackend for every non-local backend
+// name, keyed by backend name. It is set once at startup, after
+// backend-specific configuration (Vault address/token, AWS region, ...) has
+// been read, the same way SetKeyring wires the envelope encryption keyring.
+var backends = map[string]SecretBackend{}
+
+// SetSecretBackends registers the backends available to project variables.
+func SetSecretBackends(b map[string]SecretBackend) {
+	backends = b
+}
+
+func secretBackend(name string) (SecretBackend, error) {
+	b, ok := backends[name]
+	if !ok {
+		return nil, sdk.WithStack(fmt.Errorf("unknown secret backend %q", name))
+	}
+	return b, nil
+}
+
+func isRemoteBackend(name string) bool {
+	return name != "" && name != BackendLocal
+}
@@ -0,0 +1,187 @@
+package project
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// Keyring resolves Key Encryption Keys (KEK) used to wrap the per-row Data
+// Encryption Keys (DEK) that protect project secrets. Implementations are
+// expected to keep key material in memory only.
+type Keyring interface {
+	// Key returns the raw KEK bytes identified by kekID, or an error if the
+	// KEK is unknown to this keyring.
+	Key(kekID string) ([]byte, error)
+	// CurrentKEKID returns the identifier of the KEK that must be used to
+	// wrap newly generated DEKs.
+	CurrentKEKID() string
+}
+
+// keyring is the process-wide keyring used to wrap/unwrap DEKs. It must be
+// set once at startup, before any project secret is read or written.
+var keyring Keyring
+
+// SetKeyring registers the keyring used by the project package to envelope
+// secret values. It must be called during API bootstrap.
+func SetKeyring(k Keyring) {
+	keyring = k
+}
+
+// dekSize is the size in bytes of generated data encryption keys (AES-256).
+const dekSize = 32
+
+func generateDEK() ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, sdk.WrapError(err, "unable to generate data encryption key")
+	}
+	return dek, nil
+}
+
+func aesGCMEncrypt(key, data []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", sdk.WrapError(err, "unable to init cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", sdk.WrapError(err, "unable to init gcm")
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", sdk.WrapError(err, "unable to generate nonce")
+	}
+	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func aesGCMDecrypt(key []byte, data string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to decode encrypted payload")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to init cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to init gcm")
+	}
+	if len(raw) < gcm.NonceSize() {
+		return nil, sdk.WithStack(fmt.Errorf("invalid encrypted payload"))
+	}
+	nonce, ciphertext := raw[:gcm.NonceSize()], raw[gcm.NonceSize():]
+	clear, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to decrypt payload")
+	}
+	return clear, nil
+}
+
+func wrapDEK(kekID string, dek []byte) (string, error) {
+	if keyring == nil {
+		return "", sdk.WithStack(fmt.Errorf("no keyring configured"))
+	}
+	kek, err := keyring.Key(kekID)
+	if err != nil {
+		return "", sdk.WrapError(err, "unable to load KEK %s", kekID)
+	}
+	return aesGCMEncrypt(kek, dek)
+}
+
+func unwrapDEK(kekID, wrapped string) ([]byte, error) {
+	if keyring == nil {
+		return nil, sdk.WithStack(fmt.Errorf("no keyring configured"))
+	}
+	kek, err := keyring.Key(kekID)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to load KEK %s", kekID)
+	}
+	return aesGCMDecrypt(kek, wrapped)
+}
+
+// envelopeEncrypt generates a fresh DEK, encrypts value with it, wraps the
+// DEK with the current KEK and returns the KEK identifier along with a
+// payload combining the wrapped DEK and the ciphertext. The row is therefore
+// self-contained: rotating its KEK only requires unwrapping and rewrapping
+// the DEK, never touching the ciphertext itself.
+func envelopeEncrypt(value []byte) (kekID string, payload string, err error) {
+	if keyring == nil {
+		return "", "", sdk.WithStack(fmt.Errorf("no keyring configured"))
+	}
+
+	dek, err := generateDEK()
+	if err != nil {
+		return "", "", err
+	}
+
+	kekID = keyring.CurrentKEKID()
+	wrappedDEK, err := wrapDEK(kekID, dek)
+	if err != nil {
+		return "", "", err
+	}
+
+	ciphertext, err := aesGCMEncrypt(dek, value)
+	if err != nil {
+		return "", "", err
+	}
+
+	return kekID, wrappedDEK + ":" + ciphertext, nil
+}
+
+// envelopeDecrypt reverses envelopeEncrypt: it unwraps the DEK with the KEK
+// identified by kekID, then decrypts the payload with the recovered DEK.
+func envelopeDecrypt(kekID, payload string) ([]byte, error) {
+	wrappedDEK, ciphertext, ok := strings.Cut(payload, ":")
+	if !ok {
+		return nil, sdk.WithStack(fmt.Errorf("invalid envelope payload"))
+	}
+
+	dek, err := unwrapDEK(kekID, wrappedDEK)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesGCMDecrypt(dek, ciphertext)
+}
+
+// rewrapDEK decrypts value under (oldKEKID, payload), then re-envelopes the
+// recovered plaintext with a brand new DEK wrapped under newKEKID. It is the
+// building block shared by KEK rotation and on-demand DEK rotation.
+func rewrapDEK(oldKEKID, payload, newKEKID string) (string, error) {
+	clear, err := envelopeDecrypt(oldKEKID, payload)
+	if err != nil {
+		return "", err
+	}
+	_, newPayload, err := envelopeEncryptWithKEK(newKEKID, clear)
+	if err != nil {
+		return "", err
+	}
+	return newPayload, nil
+}
+
+// envelopeEncryptWithKEK behaves like envelopeEncrypt but wraps the freshly
+// generated DEK with an explicit KEK rather than the keyring's current one.
+// It is used by RotateKEK/RotateDEK, which target a specific KEK by design.
+func envelopeEncryptWithKEK(kekID string, value []byte) (string, string, error) {
+	dek, err := generateDEK()
+	if err != nil {
+		return "", "", err
+	}
+	wrappedDEK, err := wrapDEK(kekID, dek)
+	if err != nil {
+		return "", "", err
+	}
+	ciphertext, err := aesGCMEncrypt(dek, value)
+	if err != nil {
+		return "", "", err
+	}
+	return kekID, wrappedDEK + ":" + ciphertext, nil
+}
@@ -0,0 +1,269 @@
+package project
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"golang.org/x/crypto/argon2"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/ovh/cds/sdk"
+)
+
+const (
+	exportManifestVersion = 1
+	exportManifestName    = "manifest.yaml"
+	exportProjectName     = "project.yaml.enc"
+	archiveKeySize        = 32 // AES-256
+	argon2SaltSize        = 16
+)
+
+// ExportOptions controls how Export protects secret material in the
+// produced archive. Exactly one of Passphrase or RecipientPubKey must be set.
+type ExportOptions struct {
+	// Passphrase derives the archive key through argon2id.
+	Passphrase string
+	// RecipientPubKey wraps a random archive key for a specific destination
+	// instance instead of deriving it from a passphrase.
+	RecipientPubKey *rsa.PublicKey
+}
+
+// manifest is the cleartext header stored at the root of the archive. It
+// carries everything Import needs to recover the archive key, but never the
+// key itself nor any secret value.
+type manifest struct {
+	Version    int       `yaml:"version"`
+	ProjectKey string    `yaml:"project_key"`
+	ExportedAt time.Time `yaml:"exported_at"`
+
+	// Salt is set in passphrase mode; the archive key is re-derived with argon2id.
+	Salt string `yaml:"salt,omitempty"`
+	// WrappedArchiveKey is set in recipient mode; it is RSA-OAEP encrypted
+	// with the destination instance's public key.
+	WrappedArchiveKey string `yaml:"wrapped_archive_key,omitempty"`
+}
+
+// exportedProject is what gets marshalled, encrypted and stored as
+// project.yaml.enc inside the archive. Every secret value is cleartext at
+// this point in the pipeline; it never reaches disk or the terminal before
+// Export encrypts it with aesGCMEncrypt under the archive key.
+type exportedProject struct {
+	Project    sdk.Project               `yaml:"project"`
+	Variables  []sdk.Variable            `yaml:"variables"`
+	Keys       []sdk.ProjectKey          `yaml:"keys"`
+	Labels     []sdk.Label               `yaml:"labels"`
+	Audits     []sdk.ProjectVariableAudit `yaml:"audits"`
+}
+
+// Export produces a portable tar+gzip archive for projKey: the project row,
+// its variables, keys, labels and variable audit history, all protected
+// under a passphrase or a recipient's public key rather than this instance's
+// KEK, so the archive can be read on a different CDS deployment.
+func Export(ctx context.Context, db gorp.SqlExecutor, projKey string, opts ExportOptions) ([]byte, error) {
+	if (opts.Passphrase == "") == (opts.RecipientPubKey == nil) {
+		return nil, sdk.WithStack(fmt.Errorf("exactly one of Passphrase or RecipientPubKey must be set"))
+	}
+
+	exported, err := loadExportedProject(ctx, db, projKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clear, err := yaml.Marshal(exported)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to marshal project %s for export", projKey)
+	}
+
+	m := manifest{
+		Version:    exportManifestVersion,
+		ProjectKey: projKey,
+		ExportedAt: time.Now(),
+	}
+
+	var archiveKey []byte
+	if opts.Passphrase != "" {
+		salt := make([]byte, argon2SaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, sdk.WrapError(err, "unable to generate salt")
+		}
+		archiveKey = argon2.IDKey([]byte(opts.Passphrase), salt, 1, 64*1024, 4, archiveKeySize)
+		m.Salt = base64.StdEncoding.EncodeToString(salt)
+	} else {
+		archiveKey = make([]byte, archiveKeySize)
+		if _, err := rand.Read(archiveKey); err != nil {
+			return nil, sdk.WrapError(err, "unable to generate archive key")
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, opts.RecipientPubKey, archiveKey, nil)
+		if err != nil {
+			return nil, sdk.WrapError(err, "unable to wrap archive key for recipient")
+		}
+		m.WrappedArchiveKey = base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	ciphertext, err := aesGCMEncrypt(archiveKey, clear)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to encrypt project %s for export", projKey)
+	}
+
+	manifestBytes, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to marshal export manifest")
+	}
+
+	return writeArchive(manifestBytes, []byte(ciphertext))
+}
+
+func loadExportedProject(ctx context.Context, db gorp.SqlExecutor, projKey string) (exportedProject, error) {
+	var p dbProject
+	if err := db.SelectOne(&p, "SELECT * FROM project WHERE projectkey = $1", projKey); err != nil {
+		return exportedProject{}, sdk.WrapError(err, "unable to load project %s", projKey)
+	}
+	if err := p.PostGet(db); err != nil {
+		return exportedProject{}, err
+	}
+
+	var dbVars []dbProjectVariable
+	if _, err := db.Select(&dbVars, "SELECT * FROM project_variable WHERE project_id = $1", p.ID); err != nil {
+		return exportedProject{}, sdk.WrapError(err, "unable to load variables for project %s", projKey)
+	}
+	variables := make([]sdk.Variable, 0, len(dbVars))
+	for _, dbv := range dbVars {
+		v, err := dbv.Variable(ctx)
+		if err != nil {
+			return exportedProject{}, err
+		}
+		variables = append(variables, v)
+	}
+
+	var dbKeys []dbProjectKey
+	if _, err := db.Select(&dbKeys, "SELECT * FROM project_key WHERE project_id = $1", p.ID); err != nil {
+		return exportedProject{}, sdk.WrapError(err, "unable to load keys for project %s", projKey)
+	}
+	keys := make([]sdk.ProjectKey, len(dbKeys))
+	for i, dbk := range dbKeys {
+		keys[i] = dbk.ProjectKey
+	}
+
+	var dbLabels []dbLabel
+	if _, err := db.Select(&dbLabels, "SELECT * FROM project_label WHERE project_id = $1", p.ID); err != nil {
+		return exportedProject{}, sdk.WrapError(err, "unable to load labels for project %s", projKey)
+	}
+	labels := make([]sdk.Label, len(dbLabels))
+	for i, dbl := range dbLabels {
+		labels[i] = sdk.Label(dbl)
+	}
+
+	var dbAudits []dbProjectVariableAudit
+	query := "SELECT * FROM project_variable_audit WHERE project_id = $1 ORDER BY id ASC"
+	if _, err := db.Select(&dbAudits, query, p.ID); err != nil {
+		return exportedProject{}, sdk.WrapError(err, "unable to load variable audits for project %s", projKey)
+	}
+	audits := make([]sdk.ProjectVariableAudit, len(dbAudits))
+	for i, dba := range dbAudits {
+		audits[i] = sdk.ProjectVariableAudit(dba)
+	}
+
+	return exportedProject{
+		Project:   sdk.Project(p),
+		Variables: variables,
+		Keys:      keys,
+		Labels:    labels,
+		Audits:    audits,
+	}, nil
+}
+
+func writeArchive(manifestBytes, encryptedProject []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{exportManifestName, manifestBytes},
+		{exportProjectName, encryptedProject},
+	}
+
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, sdk.WrapError(err, "unable to write archive header %s", f.name)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, sdk.WrapError(err, "unable to write archive entry %s", f.name)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, sdk.WrapError(err, "unable to close archive")
+	}
+	if err := gz.Close(); err != nil {
+		return nil, sdk.WrapError(err, "unable to close archive compression")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func readArchive(archive []byte) (manifestBytes, encryptedProject []byte, err error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, sdk.WrapError(err, "unable to read archive")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, sdk.WrapError(err, "unable to read archive entry %s", hdr.Name)
+		}
+		switch hdr.Name {
+		case exportManifestName:
+			manifestBytes = data
+		case exportProjectName:
+			encryptedProject = data
+		}
+	}
+
+	if manifestBytes == nil || encryptedProject == nil {
+		return nil, nil, sdk.WithStack(fmt.Errorf("invalid export archive: missing %s or %s", exportManifestName, exportProjectName))
+	}
+
+	return manifestBytes, encryptedProject, nil
+}
+
+// archiveKeyFromPassphrase re-derives the archive key recorded in m using
+// the same argon2id parameters used at export time.
+func archiveKeyFromPassphrase(m manifest, passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(m.Salt)
+	if err != nil {
+		return nil, sdk.WrapError(err, "invalid salt in export manifest")
+	}
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, archiveKeySize), nil
+}
+
+// archiveKeyFromPrivateKey unwraps the archive key recorded in m using the
+// destination instance's private key.
+func archiveKeyFromPrivateKey(m manifest, priv *rsa.PrivateKey) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(m.WrappedArchiveKey)
+	if err != nil {
+		return nil, sdk.WrapError(err, "invalid wrapped archive key in export manifest")
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrapped, nil)
+}
+
@@ -0,0 +1,174 @@
+package project
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// buildArchive mirrors Export's own pipeline (marshal -> encrypt -> manifest
+// -> tar+gzip) without touching a database, so the archive format itself can
+// be round-tripped through readArchive and the archive-key helpers in
+// isolation from loadExportedProject/insertImportedProject, which need a
+// real *gorp.DbMap.
+func buildArchive(t *testing.T, exported exportedProject, passphrase string) (archive []byte, archiveKey []byte) {
+	t.Helper()
+
+	clear, err := yaml.Marshal(exported)
+	if err != nil {
+		t.Fatalf("marshal exported project: %v", err)
+	}
+
+	salt := make([]byte, argon2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatalf("generate salt: %v", err)
+	}
+	m := manifest{
+		Version:    exportManifestVersion,
+		ProjectKey: exported.Project.Key,
+		ExportedAt: time.Now(),
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+	}
+
+	archiveKey, err = archiveKeyFromPassphrase(m, passphrase)
+	if err != nil {
+		t.Fatalf("derive archive key: %v", err)
+	}
+
+	ciphertext, err := aesGCMEncrypt(archiveKey, clear)
+	if err != nil {
+		t.Fatalf("encrypt export payload: %v", err)
+	}
+
+	manifestBytes, err := yaml.Marshal(m)
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+
+	archive, err = writeArchive(manifestBytes, []byte(ciphertext))
+	if err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+	return archive, archiveKey
+}
+
+func TestExportArchiveRoundTripPassphrase(t *testing.T) {
+	exported := exportedProject{
+		Project:   sdk.Project{ID: 1, Key: "FOO"},
+		Variables: []sdk.Variable{{ID: 1, Name: "PASSWORD", Value: "s3cr3t", Type: "password"}},
+	}
+
+	archive, _ := buildArchive(t, exported, "correct-horse-battery-staple")
+
+	manifestBytes, encryptedProject, err := readArchive(archive)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(manifestBytes, &m); err != nil {
+		t.Fatalf("unmarshal manifest: %v", err)
+	}
+
+	archiveKey, err := archiveKeyFromPassphrase(m, "correct-horse-battery-staple")
+	if err != nil {
+		t.Fatalf("archiveKeyFromPassphrase: %v", err)
+	}
+
+	clear, err := aesGCMDecrypt(archiveKey, string(encryptedProject))
+	if err != nil {
+		t.Fatalf("decrypt archive: %v", err)
+	}
+
+	var got exportedProject
+	if err := yaml.Unmarshal(clear, &got); err != nil {
+		t.Fatalf("unmarshal decrypted project: %v", err)
+	}
+	if got.Project.Key != exported.Project.Key || len(got.Variables) != 1 || got.Variables[0].Value != "s3cr3t" {
+		t.Fatalf("got %+v, want %+v", got, exported)
+	}
+
+	wrongKey, err := archiveKeyFromPassphrase(m, "wrong passphrase")
+	if err != nil {
+		t.Fatalf("archiveKeyFromPassphrase with wrong passphrase: %v", err)
+	}
+	if _, err := aesGCMDecrypt(wrongKey, string(encryptedProject)); err == nil {
+		t.Fatal("expected decryption with the wrong passphrase to fail")
+	}
+}
+
+func TestExportArchiveRoundTripRecipientKey(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+
+	archiveKey := make([]byte, archiveKeySize)
+	if _, err := rand.Read(archiveKey); err != nil {
+		t.Fatalf("generate archive key: %v", err)
+	}
+
+	exported := exportedProject{Project: sdk.Project{ID: 1, Key: "BAR"}}
+	clear, err := yaml.Marshal(exported)
+	if err != nil {
+		t.Fatalf("marshal exported project: %v", err)
+	}
+	ciphertext, err := aesGCMEncrypt(archiveKey, clear)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &priv.PublicKey, archiveKey, nil)
+	if err != nil {
+		t.Fatalf("wrap archive key: %v", err)
+	}
+	m := manifest{WrappedArchiveKey: base64.StdEncoding.EncodeToString(wrapped)}
+
+	unwrappedKey, err := archiveKeyFromPrivateKey(m, priv)
+	if err != nil {
+		t.Fatalf("archiveKeyFromPrivateKey: %v", err)
+	}
+	if !bytes.Equal(unwrappedKey, archiveKey) {
+		t.Fatalf("got archive key %x, want %x", unwrappedKey, archiveKey)
+	}
+
+	gotClear, err := aesGCMDecrypt(unwrappedKey, string(ciphertext))
+	if err != nil {
+		t.Fatalf("decrypt with recovered archive key: %v", err)
+	}
+	if !bytes.Equal(gotClear, clear) {
+		t.Fatal("decrypted payload does not match original")
+	}
+}
+
+func TestWriteReadArchiveRoundTrip(t *testing.T) {
+	manifestBytes := []byte("manifest content")
+	projectBytes := []byte("encrypted project content")
+
+	archive, err := writeArchive(manifestBytes, projectBytes)
+	if err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+
+	gotManifest, gotProject, err := readArchive(archive)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if !bytes.Equal(gotManifest, manifestBytes) || !bytes.Equal(gotProject, projectBytes) {
+		t.Fatalf("got (%q, %q), want (%q, %q)", gotManifest, gotProject, manifestBytes, projectBytes)
+	}
+}
+
+func TestReadArchiveRejectsNonArchivePayload(t *testing.T) {
+	if _, _, err := readArchive([]byte("not an archive")); err == nil {
+		t.Fatal("expected an error for a non-archive payload")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"strconv"
 
 	"github.com/go-gorp/gorp"
 	yaml "gopkg.in/yaml.v2"
@@ -28,34 +29,128 @@ func (e dbProjectKey) Canonical() gorpmapping.CanonicalForms {
 	}
 }
 
+// PreUpdate is a db hook. It stashes the row's pre-update state so PostUpdate
+// can record it as the "before" side of a project_audit entry.
+func (e *dbProjectKey) PreUpdate(db gorp.SqlExecutor) error {
+	var previous dbProjectKey
+	if err := db.SelectOne(&previous, "SELECT * FROM project_key WHERE id = $1", e.ID); err != nil {
+		return nil // nothing to diff against, e.g. brand new row
+	}
+	stashPreUpdate(db, auditStashKey(AuditEntityProjectKey, e.ID), previous.ProjectKey)
+	return nil
+}
+
+// PostInsert is a db hook
+func (e *dbProjectKey) PostInsert(db gorp.SqlExecutor) error {
+	return recordAudit(db, e.ProjectID, AuditEntityProjectKey, strconv.FormatInt(e.ID, 10), AuditActionInsert, nil, e.ProjectKey)
+}
+
+// PostUpdate is a db hook
+func (e *dbProjectKey) PostUpdate(db gorp.SqlExecutor) error {
+	before, _ := popPreUpdate(db, auditStashKey(AuditEntityProjectKey, e.ID))
+	return recordAudit(db, e.ProjectID, AuditEntityProjectKey, strconv.FormatInt(e.ID, 10), AuditActionUpdate, before, e.ProjectKey)
+}
+
+// PostDelete is a db hook
+func (e *dbProjectKey) PostDelete(db gorp.SqlExecutor) error {
+	return recordAudit(db, e.ProjectID, AuditEntityProjectKey, strconv.FormatInt(e.ID, 10), AuditActionDelete, e.ProjectKey, nil)
+}
+
 type dbLabel sdk.Label
 
+// PreUpdate is a db hook. It stashes the row's pre-update state so PostUpdate
+// can record it as the "before" side of a project_audit entry.
+func (l *dbLabel) PreUpdate(db gorp.SqlExecutor) error {
+	var previous dbLabel
+	if err := db.SelectOne(&previous, "SELECT * FROM project_label WHERE id = $1", l.ID); err != nil {
+		return nil // nothing to diff against, e.g. brand new row
+	}
+	stashPreUpdate(db, auditStashKey(AuditEntityLabel, l.ID), sdk.Label(previous))
+	return nil
+}
+
+// PostInsert is a db hook
+func (l *dbLabel) PostInsert(db gorp.SqlExecutor) error {
+	return recordAudit(db, l.ProjectID, AuditEntityLabel, strconv.FormatInt(l.ID, 10), AuditActionInsert, nil, sdk.Label(*l))
+}
+
+// PostUpdate is a db hook
+func (l *dbLabel) PostUpdate(db gorp.SqlExecutor) error {
+	before, _ := popPreUpdate(db, auditStashKey(AuditEntityLabel, l.ID))
+	return recordAudit(db, l.ProjectID, AuditEntityLabel, strconv.FormatInt(l.ID, 10), AuditActionUpdate, before, sdk.Label(*l))
+}
+
+// PostDelete is a db hook
+func (l *dbLabel) PostDelete(db gorp.SqlExecutor) error {
+	return recordAudit(db, l.ProjectID, AuditEntityLabel, strconv.FormatInt(l.ID, 10), AuditActionDelete, sdk.Label(*l), nil)
+}
+
 type dbProjectVariable struct {
 	gorpmapping.SignedEntity
 	ID          int64  `db:"id"`
 	ProjectID   int64  `db:"project_id"`
 	Name        string `db:"var_name"`
 	ClearValue  string `db:"var_value"`
-	CipherValue string `db:"cipher_value" gorpmapping:"encrypted,ID,Name"`
+	CipherValue string `db:"cipher_value"`
 	Type        string `db:"var_type"`
+	// KekID identifies, in the keyring, the Key Encryption Key that wraps the
+	// per-row Data Encryption Key protecting CipherValue. It is part of the
+	// signed content so swapping it for another KEK is detected as tampering.
+	KekID string `db:"kek_id"`
+	// Backend names the SecretBackend holding this variable's value: "local"
+	// (or empty, for rows written before this column existed) keeps today's
+	// behavior of storing ClearValue/CipherValue in this row; any other name
+	// means the value lives in that backend and Ref is its reference there.
+	Backend string `db:"backend"`
+	Ref     string `db:"ref"`
 }
 
 func (e dbProjectVariable) Canonical() gorpmapping.CanonicalForms {
-	var _ = []interface{}{e.ProjectID, e.ID, e.Name, e.Type}
+	var _ = []interface{}{e.ProjectID, e.ID, e.Name, e.Type, e.KekID, e.Backend, e.Ref}
 	return gorpmapping.CanonicalForms{
-		"{{print .ProjectID}}{{print .ID}}{{.Name}}{{.Type}}",
+		"{{print .ProjectID}}{{print .ID}}{{.Name}}{{.Type}}{{.KekID}}{{.Backend}}{{.Ref}}",
 	}
 }
 
-func newDBProjectVariable(v sdk.Variable, projID int64) dbProjectVariable {
+// newDBProjectVariable builds the row for v. When v.Backend names a remote
+// SecretBackend, the value is stored there and only its reference is kept in
+// Ref. Otherwise secret values are protected with envelope encryption: a
+// fresh DEK encrypts the value and is itself wrapped by the keyring's
+// current KEK, whose id is stored alongside the ciphertext.
+func newDBProjectVariable(ctx context.Context, v sdk.Variable, projID int64) (dbProjectVariable, error) {
+	if isRemoteBackend(v.Backend) {
+		backend, err := secretBackend(v.Backend)
+		if err != nil {
+			return dbProjectVariable{}, err
+		}
+		ref, err := backend.Put(ctx, v.Ref, []byte(v.Value))
+		if err != nil {
+			return dbProjectVariable{}, sdk.WrapError(err, "unable to store variable %s in backend %s", v.Name, v.Backend)
+		}
+		return dbProjectVariable{
+			ID:        v.ID,
+			Name:      v.Name,
+			Type:      v.Type,
+			ProjectID: projID,
+			Backend:   v.Backend,
+			Ref:       ref,
+		}, nil
+	}
+
 	if sdk.NeedPlaceholder(v.Type) {
+		kekID, payload, err := envelopeEncrypt([]byte(v.Value))
+		if err != nil {
+			return dbProjectVariable{}, sdk.WrapError(err, "unable to encrypt variable %s", v.Name)
+		}
 		return dbProjectVariable{
 			ID:          v.ID,
 			Name:        v.Name,
-			CipherValue: v.Value,
+			CipherValue: payload,
+			KekID:       kekID,
 			Type:        v.Type,
 			ProjectID:   projID,
-		}
+			Backend:     BackendLocal,
+		}, nil
 	}
 	return dbProjectVariable{
 		ID:         v.ID,
@@ -63,17 +158,54 @@ func newDBProjectVariable(v sdk.Variable, projID int64) dbProjectVariable {
 		ClearValue: v.Value,
 		Type:       v.Type,
 		ProjectID:  projID,
-	}
+		Backend:    BackendLocal,
+	}, nil
 }
 
-func (e dbProjectVariable) Variable() sdk.Variable {
+// Variable resolves e to its sdk.Variable, fetching the value from the
+// configured SecretBackend when Backend names a remote one.
+func (e dbProjectVariable) Variable(ctx context.Context) (sdk.Variable, error) {
+	if isRemoteBackend(e.Backend) {
+		backend, err := secretBackend(e.Backend)
+		if err != nil {
+			return sdk.Variable{}, err
+		}
+		value, err := backend.Get(ctx, e.Ref)
+		if err != nil {
+			return sdk.Variable{}, sdk.WrapError(err, "unable to resolve variable %s from backend %s", e.Name, e.Backend)
+		}
+		return sdk.Variable{
+			ID:      e.ID,
+			Name:    e.Name,
+			Value:   string(value),
+			Type:    e.Type,
+			Backend: e.Backend,
+			Ref:     e.Ref,
+		}, nil
+	}
+
 	if sdk.NeedPlaceholder(e.Type) {
+		var clear []byte
+		var err error
+		if e.KekID == "" {
+			// Written before envelope encryption: cipher_value holds
+			// ciphertext from gorpmapping's old "encrypted" field tag, which
+			// relied on the secret module (the same one PostGet falls back
+			// to for legacy vcs_servers below). There is no KEK to unwrap,
+			// so decrypt it directly instead of going through envelopeDecrypt.
+			clear, err = secret.Decrypt([]byte(e.CipherValue))
+		} else {
+			clear, err = envelopeDecrypt(e.KekID, e.CipherValue)
+		}
+		if err != nil {
+			return sdk.Variable{}, sdk.WrapError(err, "unable to decrypt variable %s", e.Name)
+		}
 		return sdk.Variable{
 			ID:    e.ID,
 			Name:  e.Name,
-			Value: e.CipherValue,
+			Value: string(clear),
 			Type:  e.Type,
-		}
+		}, nil
 	}
 
 	return sdk.Variable{
@@ -81,7 +213,7 @@ func (e dbProjectVariable) Variable() sdk.Variable {
 		Name:  e.Name,
 		Value: e.ClearValue,
 		Type:  e.Type,
-	}
+	}, nil
 }
 
 func init() {
@@ -96,10 +228,11 @@ func init() {
 func (p *dbProject) PostGet(db gorp.SqlExecutor) error {
 	var fields = struct {
 		Metadata   sql.NullString `db:"metadata"`
-		VCSServers []byte         `db:"vcs_servers"`
+		VCSServers string         `db:"vcs_servers"`
+		KekID      sql.NullString `db:"kek_id"`
 	}{}
 
-	if err := db.QueryRow("select metadata,vcs_servers from project where id = $1", p.ID).Scan(&fields.Metadata, &fields.VCSServers); err != nil {
+	if err := db.QueryRow("select metadata,vcs_servers,kek_id from project where id = $1", p.ID).Scan(&fields.Metadata, &fields.VCSServers, &fields.KekID); err != nil {
 		return err
 	}
 
@@ -108,7 +241,17 @@ func (p *dbProject) PostGet(db gorp.SqlExecutor) error {
 	}
 
 	if len(fields.VCSServers) > 0 {
-		clearVCSServer, err := secret.Decrypt([]byte(fields.VCSServers))
+		var clearVCSServer []byte
+		var err error
+		if fields.KekID.Valid && fields.KekID.String != "" {
+			clearVCSServer, err = envelopeDecrypt(fields.KekID.String, fields.VCSServers)
+		} else {
+			// kek_id is NULL/empty on rows written before envelope
+			// encryption: vcs_servers still holds secret.Encrypt ciphertext
+			// directly. Falling back here instead of skipping decryption
+			// keeps existing VCS server config from silently disappearing.
+			clearVCSServer, err = secret.Decrypt([]byte(fields.VCSServers))
+		}
 		if err != nil {
 			return err
 		}
@@ -125,33 +268,96 @@ func (p *dbProject) PostGet(db gorp.SqlExecutor) error {
 	return nil
 }
 
+// PreUpdate is a db hook. It loads and decrypts the row's current
+// vcs_servers so PostUpdate can record a redacted diff in project_audit.
+func (p *dbProject) PreUpdate(db gorp.SqlExecutor) error {
+	var fields = struct {
+		VCSServers sql.NullString `db:"vcs_servers"`
+		KekID      sql.NullString `db:"kek_id"`
+	}{}
+	query := "SELECT vcs_servers, kek_id FROM project WHERE id = $1"
+	if err := db.QueryRow(query, p.ID).Scan(&fields.VCSServers, &fields.KekID); err != nil {
+		return nil // nothing to diff against, e.g. brand new row
+	}
+	if !fields.VCSServers.Valid || !fields.KekID.Valid || fields.VCSServers.String == "" {
+		return nil
+	}
+
+	clear, err := envelopeDecrypt(fields.KekID.String, fields.VCSServers.String)
+	if err != nil {
+		return nil // unreadable with the previous KEK, nothing usable to diff
+	}
+
+	stashPreUpdate(db, auditStashKey(AuditEntityVCSServers, p.ID), clear)
+	return nil
+}
+
+// persistVCSServers writes the project's metadata and, if set, its encrypted
+// vcs_servers/kek_id, returning the clear-text YAML that was just persisted
+// so callers can build an audit diff from it. hasVCSServers is false when
+// there was nothing to encrypt, in which case clear is nil.
+func (p *dbProject) persistVCSServers(db gorp.SqlExecutor) (clear []byte, hasVCSServers bool, err error) {
+	bm, err := json.Marshal(p.Metadata)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(p.VCSServers) == 0 {
+		_, err := db.Exec("update project set metadata = $2 where id = $1", p.ID, bm)
+		return nil, false, err
+	}
+
+	b1, err := yaml.Marshal(p.VCSServers)
+	if err != nil {
+		return nil, false, err
+	}
+	kekID, encryptedVCSServerStr, err := envelopeEncrypt(b1)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := db.Exec("update project set metadata = $2, vcs_servers = $3, kek_id = $4 where id = $1", p.ID, bm, encryptedVCSServerStr, kekID); err != nil {
+		return nil, false, err
+	}
+	return b1, true, nil
+}
+
 // PostUpdate is a db hook
 func (p *dbProject) PostUpdate(db gorp.SqlExecutor) error {
-	bm, errm := json.Marshal(p.Metadata)
-	if errm != nil {
-		return errm
+	clear, hasVCSServers, err := p.persistVCSServers(db)
+	if err != nil {
+		return err
+	}
+	if !hasVCSServers {
+		return nil
 	}
 
-	if len(p.VCSServers) > 0 {
-		b1, err := yaml.Marshal(p.VCSServers)
+	if before, ok := popPreUpdate(db, auditStashKey(AuditEntityVCSServers, p.ID)); ok {
+		diff, err := redactVCSServersDiff(before.([]byte), clear)
 		if err != nil {
 			return err
 		}
-		encryptedVCSServerStr, err := secret.Encrypt(b1)
-		if err != nil {
-			return err
-		}
-		_, err = db.Exec("update project set metadata = $2, vcs_servers = $3 where id = $1", p.ID, bm, encryptedVCSServerStr)
-		return err
+		return recordAudit(db, p.ID, AuditEntityVCSServers, strconv.FormatInt(p.ID, 10), AuditActionUpdate, diff["before"], diff["after"])
 	}
-
-	_, err := db.Exec("update project set metadata = $2 where id = $1", p.ID, bm)
-	return err
+	return nil
 }
 
-// PostInsert is a db hook
+// PostInsert is a db hook. Unlike PostUpdate it has no pre-update snapshot to
+// diff against, so a first-write vcs_servers config is recorded as an insert
+// with no before side, rather than being silently left out of the trail.
 func (p *dbProject) PostInsert(db gorp.SqlExecutor) error {
-	return p.PostUpdate(db)
+	clear, hasVCSServers, err := p.persistVCSServers(db)
+	if err != nil {
+		return err
+	}
+	if !hasVCSServers {
+		return nil
+	}
+
+	diff, err := redactVCSServersDiff(nil, clear)
+	if err != nil {
+		return err
+	}
+	return recordAudit(db, p.ID, AuditEntityVCSServers, strconv.FormatInt(p.ID, 10), AuditActionInsert, nil, diff["after"])
 }
 
 // PostGet is a db hook
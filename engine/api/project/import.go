@@ -0,0 +1,135 @@
+package project
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/go-gorp/gorp"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/sdk"
+)
+
+// ImportOptions mirrors ExportOptions: exactly one of Passphrase or
+// PrivateKey must be set, matching whichever mode the archive was produced
+// with.
+type ImportOptions struct {
+	Passphrase string
+	PrivateKey *rsa.PrivateKey
+}
+
+// Import restores a project from an archive produced by Export. Secret
+// values are decrypted with the archive key, then re-encrypted under this
+// instance's own KEK (via newDBProjectVariable) and inserted with new IDs
+// through gorpmapping.InsertAndSign wherever the row is a SignedEntity; names
+// and audit ordering are preserved, but the imported project never shares
+// identifiers with its origin.
+func Import(ctx context.Context, db gorp.SqlExecutor, archive []byte, opts ImportOptions) (*sdk.Project, error) {
+	if (opts.Passphrase == "") == (opts.PrivateKey == nil) {
+		return nil, sdk.WithStack(fmt.Errorf("exactly one of Passphrase or PrivateKey must be set"))
+	}
+
+	manifestBytes, encryptedProject, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(manifestBytes, &m); err != nil {
+		return nil, sdk.WrapError(err, "invalid export manifest")
+	}
+
+	var archiveKey []byte
+	if opts.Passphrase != "" {
+		archiveKey, err = archiveKeyFromPassphrase(m, opts.Passphrase)
+	} else {
+		archiveKey, err = archiveKeyFromPrivateKey(m, opts.PrivateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clear, err := aesGCMDecrypt(archiveKey, string(encryptedProject))
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to decrypt project archive for %s (wrong passphrase or key?)", m.ProjectKey)
+	}
+
+	var exported exportedProject
+	if err := yaml.Unmarshal(clear, &exported); err != nil {
+		return nil, sdk.WrapError(err, "invalid project archive content")
+	}
+
+	return insertImportedProject(ctx, db, exported)
+}
+
+func insertImportedProject(ctx context.Context, db gorp.SqlExecutor, exported exportedProject) (*sdk.Project, error) {
+	// dbProject, dbLabel and dbProjectVariableAudit have no Canonical()/
+	// SignedEntity of their own (see gorp_model.go), so a plain db.Insert is
+	// the right call for them; only dbProjectVariable and dbProjectKey below
+	// need the signing insert path.
+	p := dbProject(exported.Project)
+	p.ID = 0
+	if err := db.Insert(&p); err != nil {
+		return nil, sdk.WrapError(err, "unable to import project %s", exported.Project.Key)
+	}
+
+	oldToNewVarID := map[int64]int64{}
+	for _, v := range exported.Variables {
+		oldID := v.ID
+		v.ID = 0
+		// The source instance's backend reference (e.g. a vault path) is
+		// meaningless here; land every imported variable as a local,
+		// envelope-encrypted row and let the operator re-migrate it to a
+		// remote backend with MigrateVariablesToBackend if desired.
+		v.Backend = ""
+		v.Ref = ""
+		dbv, err := newDBProjectVariable(ctx, v, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		// dbProjectVariable is a SignedEntity: a plain db.Insert would leave
+		// sig/signer unset, and the row would fail verification the next
+		// time it's read. InsertAndSign computes and persists the signature
+		// alongside the insert.
+		if err := gorpmapping.InsertAndSign(db, &dbv); err != nil {
+			return nil, sdk.WrapError(err, "unable to import variable %s", v.Name)
+		}
+		oldToNewVarID[oldID] = dbv.ID
+	}
+
+	for _, k := range exported.Keys {
+		k.ID = 0
+		k.ProjectID = p.ID
+		dbk := dbProjectKey{ProjectKey: k}
+		// Same signing requirement as project_variable above.
+		if err := gorpmapping.InsertAndSign(db, &dbk); err != nil {
+			return nil, sdk.WrapError(err, "unable to import key %s", k.Name)
+		}
+	}
+
+	for _, l := range exported.Labels {
+		l.ID = 0
+		l.ProjectID = p.ID
+		dbl := dbLabel(l)
+		if err := db.Insert(&dbl); err != nil {
+			return nil, sdk.WrapError(err, "unable to import label %s", l.Name)
+		}
+	}
+
+	for _, a := range exported.Audits {
+		a.ID = 0
+		a.ProjectID = p.ID
+		if newID, ok := oldToNewVarID[a.VariableID]; ok {
+			a.VariableID = newID
+		}
+		dba := dbProjectVariableAudit(a)
+		if err := db.Insert(&dba); err != nil {
+			return nil, sdk.WrapError(err, "unable to import variable audit for project %s", exported.Project.Key)
+		}
+	}
+
+	result := sdk.Project(p)
+	return &result, nil
+}
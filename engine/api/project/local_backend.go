@@ -0,0 +1,40 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ovh/cds/sdk"
+)
+
+const localRefPrefix = "local://"
+
+// LocalBackend implements SecretBackend without any external service or
+// extra storage: Put envelope-encrypts the value (the same mechanism
+// CipherValue already uses) and returns the ciphertext itself, prefixed with
+// its KEK id, as a self-contained reference. newDBProjectVariable and
+// Variable do not go through it: a variable whose Backend is "local" or empty
+// keeps its value in its own project_variable row.
+type LocalBackend struct{}
+
+func (LocalBackend) Put(ctx context.Context, ref string, value []byte) (string, error) {
+	kekID, payload, err := envelopeEncrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return localRefPrefix + kekID + ":" + payload, nil
+}
+
+func (LocalBackend) Get(ctx context.Context, ref string) ([]byte, error) {
+	kekID, payload, ok := strings.Cut(strings.TrimPrefix(ref, localRefPrefix), ":")
+	if !ok {
+		return nil, sdk.WithStack(fmt.Errorf("invalid local secret reference"))
+	}
+	return envelopeDecrypt(kekID, payload)
+}
+
+func (LocalBackend) Delete(ctx context.Context, ref string) error {
+	// The reference is self-contained; there is nothing external to clean up.
+	return nil
+}
@@ -0,0 +1,37 @@
+package project
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalBackendPutGetRoundTrip(t *testing.T) {
+	keyring = newFakeKeyring("kek-1")
+	defer func() { keyring = nil }()
+
+	var b LocalBackend
+	ctx := context.Background()
+
+	ref, err := b.Put(ctx, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if ref == "" {
+		t.Fatal("expected a non-empty reference")
+	}
+
+	value, err := b.Get(ctx, ref)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(value) != "hunter2" {
+		t.Fatalf("got %q, want %q", value, "hunter2")
+	}
+}
+
+func TestLocalBackendGetRejectsInvalidRef(t *testing.T) {
+	var b LocalBackend
+	if _, err := b.Get(context.Background(), localRefPrefix+"missing-separator"); err == nil {
+		t.Fatal("expected an error for a reference with no kekID:payload separator")
+	}
+}
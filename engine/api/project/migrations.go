@@ -0,0 +1,134 @@
+package project
+
+import (
+	"github.com/go-gorp/gorp"
+
+	"github.com/ovh/cds/engine/api/database/migrations"
+)
+
+func init() {
+	migrations.Register(migrations.Migration{
+		ID:          20190101000000,
+		Description: "create project, project_key and project_label tables",
+		Apply: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS project (
+					id BIGSERIAL PRIMARY KEY,
+					projectkey VARCHAR(50) NOT NULL UNIQUE,
+					name TEXT NOT NULL,
+					metadata JSONB,
+					vcs_servers BYTEA,
+					sig BYTEA,
+					signer TEXT
+				);
+				CREATE TABLE IF NOT EXISTS project_key (
+					id BIGSERIAL PRIMARY KEY,
+					project_id BIGINT NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					sig BYTEA,
+					signer TEXT
+				);
+				CREATE TABLE IF NOT EXISTS project_label (
+					id BIGSERIAL PRIMARY KEY,
+					project_id BIGINT NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					color TEXT
+				);`)
+			return err
+		},
+		Rollback: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`DROP TABLE IF EXISTS project_label; DROP TABLE IF EXISTS project_key; DROP TABLE IF EXISTS project;`)
+			return err
+		},
+	})
+
+	migrations.Register(migrations.Migration{
+		ID:          20190101000001,
+		Description: "create project_variable and project_variable_audit tables",
+		Apply: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS project_variable (
+					id BIGSERIAL PRIMARY KEY,
+					project_id BIGINT NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+					var_name TEXT NOT NULL,
+					var_value TEXT,
+					cipher_value BYTEA,
+					var_type TEXT NOT NULL,
+					sig BYTEA,
+					signer TEXT
+				);
+				CREATE TABLE IF NOT EXISTS project_variable_audit (
+					id BIGSERIAL PRIMARY KEY,
+					project_id BIGINT NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+					variable_before JSONB,
+					variable_after JSONB,
+					versionned TIMESTAMPTZ,
+					author TEXT
+				);`)
+			return err
+		},
+		Rollback: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`DROP TABLE IF EXISTS project_variable_audit; DROP TABLE IF EXISTS project_variable;`)
+			return err
+		},
+	})
+
+	migrations.Register(migrations.Migration{
+		ID:          20260726000000,
+		Description: "add kek_id to project and project_variable for envelope encryption",
+		Apply: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				ALTER TABLE project ADD COLUMN IF NOT EXISTS kek_id VARCHAR(128);
+				ALTER TABLE project_variable ADD COLUMN IF NOT EXISTS kek_id VARCHAR(128);`)
+			return err
+		},
+		Rollback: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				ALTER TABLE project_variable DROP COLUMN IF EXISTS kek_id;
+				ALTER TABLE project DROP COLUMN IF EXISTS kek_id;`)
+			return err
+		},
+	})
+
+	migrations.Register(migrations.Migration{
+		ID:          20260726000001,
+		Description: "create project_audit table",
+		Apply: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS project_audit (
+					id BIGSERIAL PRIMARY KEY,
+					project_id BIGINT NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+					entity_type TEXT NOT NULL,
+					entity_id TEXT NOT NULL,
+					author_id TEXT NOT NULL,
+					action TEXT NOT NULL,
+					before JSONB,
+					after JSONB,
+					created_at TIMESTAMPTZ NOT NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_project_audit_project_id ON project_audit(project_id);`)
+			return err
+		},
+		Rollback: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`DROP TABLE IF EXISTS project_audit;`)
+			return err
+		},
+	})
+
+	migrations.Register(migrations.Migration{
+		ID:          20260726000002,
+		Description: "add backend and ref to project_variable for pluggable secret backends",
+		Apply: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				ALTER TABLE project_variable ADD COLUMN IF NOT EXISTS backend VARCHAR(64) NOT NULL DEFAULT '';
+				ALTER TABLE project_variable ADD COLUMN IF NOT EXISTS ref TEXT NOT NULL DEFAULT '';`)
+			return err
+		},
+		Rollback: func(tx *gorp.Transaction) error {
+			_, err := tx.Exec(`
+				ALTER TABLE project_variable DROP COLUMN IF EXISTS ref;
+				ALTER TABLE project_variable DROP COLUMN IF EXISTS backend;`)
+			return err
+		},
+	})
+}
@@ -0,0 +1,158 @@
+package project
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/go-gorp/gorp"
+
+	"github.com/ovh/cds/engine/api/database/gorpmapping"
+	"github.com/ovh/cds/sdk"
+)
+
+// RotateKEK rewraps every project_variable and project (vcs_servers) row
+// currently protected by oldKEKID so that they are protected by newKEKID
+// instead. Rows are processed one at a time, each in its own short
+// transaction, so the rotation can run online without holding a lock across
+// the whole table.
+func RotateKEK(ctx context.Context, db *gorp.DbMap, oldKEKID, newKEKID string) error {
+	if err := rotateProjectVariablesKEK(ctx, db, oldKEKID, newKEKID); err != nil {
+		return err
+	}
+	return rotateProjectsKEK(ctx, db, oldKEKID, newKEKID)
+}
+
+func rotateProjectVariablesKEK(ctx context.Context, db *gorp.DbMap, oldKEKID, newKEKID string) error {
+	var ids []int64
+	if _, err := db.Select(&ids, "SELECT id FROM project_variable WHERE kek_id = $1", oldKEKID); err != nil {
+		return sdk.WrapError(err, "unable to list project_variable rows for KEK %s", oldKEKID)
+	}
+
+	for _, id := range ids {
+		if err := rotateProjectVariableRow(ctx, db, id, oldKEKID, newKEKID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateProjectVariableRow(ctx context.Context, db *gorp.DbMap, id int64, oldKEKID, newKEKID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return sdk.WrapError(err, "unable to start transaction")
+	}
+	defer tx.Rollback() // nolint
+
+	var pv dbProjectVariable
+	query := "SELECT * FROM project_variable WHERE id = $1 FOR UPDATE"
+	if err := tx.SelectOne(&pv, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return sdk.WrapError(err, "unable to load project_variable %d", id)
+	}
+
+	// Another rotation or a concurrent write may have already moved this row
+	// off oldKEKID between the listing query and this read; skip it.
+	if pv.KekID != oldKEKID || !sdk.NeedPlaceholder(pv.Type) {
+		return nil
+	}
+
+	newPayload, err := rewrapDEK(pv.KekID, pv.CipherValue, newKEKID)
+	if err != nil {
+		return sdk.WrapError(err, "unable to rewrap project_variable %d", id)
+	}
+
+	// kek_id is part of dbProjectVariable's Canonical form (cipher_value is
+	// not), so a plain UPDATE would leave the row's existing signature
+	// covering the old kek_id, and the row would fail signature verification
+	// once read back with the new one. Route through UpdateAndSign so the
+	// signature is regenerated along with the column it covers.
+	pv.CipherValue = newPayload
+	pv.KekID = newKEKID
+	if err := gorpmapping.UpdateAndSign(tx, &pv); err != nil {
+		return sdk.WrapError(err, "unable to update project_variable %d", id)
+	}
+
+	return sdk.WithStack(tx.Commit())
+}
+
+func rotateProjectsKEK(ctx context.Context, db *gorp.DbMap, oldKEKID, newKEKID string) error {
+	var ids []int64
+	if _, err := db.Select(&ids, "SELECT id FROM project WHERE kek_id = $1", oldKEKID); err != nil {
+		return sdk.WrapError(err, "unable to list project rows for KEK %s", oldKEKID)
+	}
+
+	for _, id := range ids {
+		if err := rotateProjectRow(ctx, db, id, oldKEKID, newKEKID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateProjectRow(ctx context.Context, db *gorp.DbMap, id int64, oldKEKID, newKEKID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return sdk.WrapError(err, "unable to start transaction")
+	}
+	defer tx.Rollback() // nolint
+
+	var fields = struct {
+		VCSServers string `db:"vcs_servers"`
+		KekID      string `db:"kek_id"`
+	}{}
+	query := "SELECT vcs_servers, kek_id FROM project WHERE id = $1 FOR UPDATE"
+	if err := tx.QueryRow(query, id).Scan(&fields.VCSServers, &fields.KekID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return sdk.WrapError(err, "unable to load project %d", id)
+	}
+
+	if fields.KekID != oldKEKID || len(fields.VCSServers) == 0 {
+		return nil
+	}
+
+	newPayload, err := rewrapDEK(fields.KekID, fields.VCSServers, newKEKID)
+	if err != nil {
+		return sdk.WrapError(err, "unable to rewrap vcs_servers for project %d", id)
+	}
+
+	// Unlike project_variable, dbProject has no Canonical()/SignedEntity of
+	// its own (see gorp_model.go) - its PostUpdate hook already writes
+	// vcs_servers/kek_id with a plain db.Exec, the same as this statement.
+	// There is no signature to regenerate here.
+	update := "UPDATE project SET vcs_servers = $2, kek_id = $3 WHERE id = $1"
+	if _, err := tx.Exec(update, id, newPayload, newKEKID); err != nil {
+		return sdk.WrapError(err, "unable to update project %d", id)
+	}
+
+	return sdk.WithStack(tx.Commit())
+}
+
+// RotateDEK re-encrypts a single project_variable with a brand new DEK,
+// wrapped under its current KEK. Use it on demand, for example after a
+// suspected compromise of one secret, without waiting for a full KEK
+// rotation.
+func RotateDEK(ctx context.Context, db gorp.SqlExecutor, varID int64) error {
+	var pv dbProjectVariable
+	if err := db.SelectOne(&pv, "SELECT * FROM project_variable WHERE id = $1", varID); err != nil {
+		return sdk.WrapError(err, "unable to load project_variable %d", varID)
+	}
+
+	if !sdk.NeedPlaceholder(pv.Type) {
+		return sdk.WithStack(sdk.ErrInvalidData)
+	}
+
+	newPayload, err := rewrapDEK(pv.KekID, pv.CipherValue, pv.KekID)
+	if err != nil {
+		return sdk.WrapError(err, "unable to re-encrypt project_variable %d", varID)
+	}
+
+	if _, err := db.Exec("UPDATE project_variable SET cipher_value = $2 WHERE id = $1", varID, newPayload); err != nil {
+		return sdk.WrapError(err, "unable to update project_variable %d", varID)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/ovh/cds/sdk"
+)
+
+// VaultBackend stores project secrets in a HashiCorp Vault KV v2 mount.
+// References look like vault://<mount>/<path>?version=N.
+type VaultBackend struct {
+	Client *vaultapi.Client
+}
+
+func parseVaultRef(ref string) (mount, path string, version int, err error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", 0, sdk.WrapError(err, "invalid vault reference %q", ref)
+	}
+	if u.Scheme != BackendVault {
+		return "", "", 0, sdk.WithStack(fmt.Errorf("invalid vault reference scheme %q", u.Scheme))
+	}
+
+	mount = strings.Trim(u.Host, "/")
+	path = strings.Trim(u.Path, "/")
+	if v := u.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			return "", "", 0, sdk.WrapError(err, "invalid vault reference version %q", v)
+		}
+	}
+	return mount, path, version, nil
+}
+
+func (b VaultBackend) Get(ctx context.Context, ref string) ([]byte, error) {
+	mount, path, version, err := parseVaultRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string][]string{}
+	if version > 0 {
+		params["version"] = []string{strconv.Itoa(version)}
+	}
+
+	secret, err := b.Client.Logical().ReadWithDataWithContext(ctx, fmt.Sprintf("%s/data/%s", mount, path), params)
+	if err != nil {
+		return nil, sdk.WrapError(err, "unable to read vault secret %s", ref)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, sdk.WithStack(fmt.Errorf("vault secret %s not found", ref))
+	}
+
+	data, _ := secret.Data["data"].(map[string]interface{})
+	value, _ := data["value"].(string)
+	return []byte(value), nil
+}
+
+func (b VaultBackend) Put(ctx context.Context, ref string, value []byte) (string, error) {
+	mount, path, _, err := parseVaultRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := b.Client.Logical().WriteWithContext(ctx, fmt.Sprintf("%s/data/%s", mount, path), map[string]interface{}{
+		"data": map[string]interface{}{"value": string(value)},
+	})
+	if err != nil {
+		return "", sdk.WrapError(err, "unable to write vault secret %s", ref)
+	}
+
+	version := 0
+	if secret != nil {
+		if v, ok := secret.Data["version"].(json.Number); ok {
+			version, _ = strconv.Atoi(v.String())
+		}
+	}
+
+	return fmt.Sprintf("vault://%s/%s?version=%d", mount, path, version), nil
+}
+
+func (b VaultBackend) Delete(ctx context.Context, ref string) error {
+	mount, path, _, err := parseVaultRef(ref)
+	if err != nil {
+		return err
+	}
+	_, err = b.Client.Logical().DeleteWithContext(ctx, fmt.Sprintf("%s/metadata/%s", mount, path))
+	return sdk.WithStack(err)
+}
@@ -0,0 +1,56 @@
+package project
+
+import "testing"
+
+func TestParseVaultRef(t *testing.T) {
+	cases := []struct {
+		name        string
+		ref         string
+		wantMount   string
+		wantPath    string
+		wantVersion int
+		wantErr     bool
+	}{
+		{
+			name:      "no version",
+			ref:       "vault://cds/projects/FOO/MY_SECRET",
+			wantMount: "cds",
+			wantPath:  "projects/FOO/MY_SECRET",
+		},
+		{
+			name:        "with version",
+			ref:         "vault://cds/projects/FOO/MY_SECRET?version=3",
+			wantMount:   "cds",
+			wantPath:    "projects/FOO/MY_SECRET",
+			wantVersion: 3,
+		},
+		{
+			name:    "wrong scheme",
+			ref:     "aws-secretsmanager://FOO",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			ref:     "vault://cds/FOO?version=not-a-number",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mount, path, version, err := parseVaultRef(c.ref)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mount != c.wantMount || path != c.wantPath || version != c.wantVersion {
+				t.Fatalf("got (%q, %q, %d), want (%q, %q, %d)", mount, path, version, c.wantMount, c.wantPath, c.wantVersion)
+			}
+		})
+	}
+}